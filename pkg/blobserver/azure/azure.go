@@ -0,0 +1,62 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package azure registers the "azureblob" blobserver storage type,
+// storing blobs in an Azure Blob Storage container. It shares its
+// upload buffering with pkg/blobserver/s3 via pkg/blobserver/objstore.
+package azure
+
+import (
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+// azureStorage implements blobserver.Storage on top of an Azure Blob
+// Storage container.
+type azureStorage struct {
+	client    *azureClient
+	container string
+
+	uploader *azureUploader
+}
+
+// NewFromConfig builds the azure backend's blobserver.Storage from
+// config. It's exported so pkg/blobserver/objectstorage can dispatch
+// to it when config selects "backend": "azureblob".
+func NewFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	account := config.RequiredString("account")
+	accountKey := config.RequiredString("account_key")
+	container := config.RequiredString("container")
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := newAzureClient(account, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &azureStorage{
+		client:    client,
+		container: container,
+		uploader:  &azureUploader{client: client, container: container},
+	}, nil
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("azureblob", blobserver.StorageConstructor(NewFromConfig))
+}
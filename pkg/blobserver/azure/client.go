@@ -0,0 +1,300 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiVersion = "2019-12-12"
+
+// azureClient speaks just enough of the Azure Blob Storage REST API
+// (Shared Key authentication) to support this package's
+// blobserver.Storage implementation.
+type azureClient struct {
+	account    string
+	accountKey []byte // decoded from the base64 account key
+
+	httpClient *http.Client
+}
+
+func newAzureClient(account, accountKeyBase64 string) (*azureClient, error) {
+	key, err := base64.StdEncoding.DecodeString(accountKeyBase64)
+	if err != nil {
+		return nil, fmt.Errorf("azure: invalid account_key: %v", err)
+	}
+	return &azureClient{account: account, accountKey: key}, nil
+}
+
+func (c *azureClient) client() *http.Client {
+	if c.httpClient != nil {
+		return c.httpClient
+	}
+	return http.DefaultClient
+}
+
+func (c *azureClient) blobURL(container, key string) string {
+	return fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", c.account, container, url.QueryEscape(key))
+}
+
+// newRequest builds an unsigned request against container/key, with
+// the x-ms-date and x-ms-version headers set. Callers must set any
+// other headers that need to be covered by the signature (Content-MD5,
+// x-ms-blob-type, ...) and then call c.sign before issuing it.
+func (c *azureClient) newRequest(method, container, key, query string, contentLength int64, body io.Reader) (*http.Request, error) {
+	reqURL := c.blobURL(container, key)
+	if query != "" {
+		reqURL += "?" + query
+	}
+	req, err := http.NewRequest(method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = contentLength
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", apiVersion)
+	return req, nil
+}
+
+// sign implements Azure's Shared Key authentication scheme:
+// https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key
+func (c *azureClient) sign(req *http.Request, container, key string) error {
+	var amzHeaders []string
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-ms-") {
+			amzHeaders = append(amzHeaders, lk)
+		}
+	}
+	sort.Strings(amzHeaders)
+
+	var canonicalizedHeaders strings.Builder
+	for _, k := range amzHeaders {
+		fmt.Fprintf(&canonicalizedHeaders, "%s:%s\n", k, strings.Join(req.Header[http.CanonicalHeaderKey(k)], ","))
+	}
+
+	canonicalizedResource := fmt.Sprintf("/%s/%s/%s", c.account, container, key)
+	canonicalizedResource += canonicalizedQueryAzure(req.URL.RawQuery)
+
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", // Date (we use x-ms-date instead)
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalizedHeaders.String() + canonicalizedResource,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, c.accountKey)
+	mac.Write([]byte(stringToSign))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", c.account, sig))
+	return nil
+}
+
+// canonicalizedQueryAzure returns the CanonicalizedResource query
+// component of Azure's Shared Key string-to-sign: one "\nname:value"
+// line per parameter, lowercased and sorted by name, with repeated
+// values for the same name comma-joined
+// (https://learn.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key).
+func canonicalizedQueryAzure(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var buf strings.Builder
+	for _, k := range names {
+		vs := values[k]
+		sort.Strings(vs)
+		fmt.Fprintf(&buf, "\n%s:%s", strings.ToLower(k), strings.Join(vs, ","))
+	}
+	return buf.String()
+}
+
+// PutObject uploads body (size bytes, with the given MD5 digest) to
+// key within container as a block blob.
+func (c *azureClient) PutObject(key, container string, md5Sum []byte, size int64, body io.Reader) error {
+	req, err := c.newRequest("PUT", container, key, "", size, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum))
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	if err := c.sign(req, container, key); err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("azure: PUT %s/%s: %s", container, key, resp.Status)
+	}
+	return nil
+}
+
+// Stat returns the size of key within container.
+func (c *azureClient) Stat(key, container string) (size int64, err error) {
+	req, err := c.newRequest("HEAD", container, key, "", 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.sign(req, container, key); err != nil {
+		return 0, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("azure: HEAD %s/%s: %s", container, key, resp.Status)
+	}
+	return strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+}
+
+// Get returns the contents of key within container.
+func (c *azureClient) Get(key, container string) (io.ReadCloser, int64, error) {
+	req, err := c.newRequest("GET", container, key, "", 0, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := c.sign(req, container, key); err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, 0, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("azure: GET %s/%s: %s", container, key, resp.Status)
+	}
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	return resp.Body, size, nil
+}
+
+type azureBlobEntry struct {
+	Name       string `xml:"Name"`
+	Properties struct {
+		ContentLength int64 `xml:"Content-Length"`
+	} `xml:"Properties"`
+}
+
+type azureListResult struct {
+	Blobs struct {
+		Blob []azureBlobEntry `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// List returns up to maxResults blobs in container, starting after
+// the given marker (an opaque continuation token, empty for the
+// first page).
+func (c *azureClient) List(container, marker string, maxResults int) (entries []azureBlobEntry, nextMarker string, err error) {
+	query := fmt.Sprintf("restype=container&comp=list&maxresults=%d", maxResults)
+	if marker != "" {
+		query += "&marker=" + url.QueryEscape(marker)
+	}
+	req, err := c.newRequest("GET", container, "", query, 0, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := c.sign(req, container, ""); err != nil {
+		return nil, "", err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("azure: List %s: %s", container, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var result azureListResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, "", fmt.Errorf("azure: parsing list response: %v", err)
+	}
+	return result.Blobs.Blob, result.NextMarker, nil
+}
+
+// Delete removes key from container.
+func (c *azureClient) Delete(key, container string) error {
+	req, err := c.newRequest("DELETE", container, key, "", 0, nil)
+	if err != nil {
+		return err
+	}
+	if err := c.sign(req, container, key); err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("azure: DELETE %s/%s: %s", container, key, resp.Status)
+	}
+	return nil
+}
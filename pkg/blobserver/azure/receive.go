@@ -0,0 +1,43 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"io"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver/objstore"
+)
+
+// ReceiveBlob slurps source to compute its MD5 (for Azure's
+// Content-MD5 header) and uploads it as a block blob.
+func (sto *azureStorage) ReceiveBlob(b blob.Ref, source io.Reader) (sr blob.SizedRef, err error) {
+	slurper := objstore.NewSlurper(b)
+	defer slurper.Cleanup()
+
+	size, err := io.Copy(slurper, source)
+	if err != nil {
+		return sr, err
+	}
+
+	err = sto.uploader.Put(context.Background(), b.String(), size, slurper.MD5(), slurper.SHA256(), slurper)
+	if err != nil {
+		return sr, err
+	}
+	return blob.SizedRef{Ref: b, Size: uint32(size)}, nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"io"
+	"os"
+
+	"camlistore.org/pkg/blob"
+)
+
+// Fetch returns the contents of b.
+func (sto *azureStorage) Fetch(b blob.Ref) (file io.ReadCloser, size uint32, err error) {
+	rc, sz, err := sto.client.Get(b.String(), sto.container)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rc, uint32(sz), nil
+}
+
+// StatBlobs sends a blob.SizedRef on dest for each of blobs that
+// exists in the container.
+func (sto *azureStorage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	for _, br := range blobs {
+		size, err := sto.client.Stat(br.String(), sto.container)
+		if err == os.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dest <- blob.SizedRef{Ref: br, Size: uint32(size)}
+	}
+	return nil
+}
+
+// RemoveBlobs deletes each of blobs from the container.
+func (sto *azureStorage) RemoveBlobs(blobs []blob.Ref) error {
+	for _, br := range blobs {
+		if err := sto.client.Delete(br.String(), sto.container); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnumerateBlobs sends up to limit blobs in the container, in sorted
+// order, with blobref strings greater than after.
+//
+// Unlike S3's marker, Azure's List marker is an opaque continuation
+// token and can't be seeded with after, so this pages through the
+// container from the start, using the server's NextMarker to drive
+// continuation, and skips entries up to and including after itself.
+func (sto *azureStorage) EnumerateBlobs(dest chan<- blob.SizedRef, after string, limit int) error {
+	defer close(dest)
+	marker := ""
+	sent := 0
+	for {
+		entries, nextMarker, err := sto.client.List(sto.container, marker, limit)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Name <= after {
+				continue
+			}
+			br, ok := blob.Parse(e.Name)
+			if !ok {
+				continue
+			}
+			dest <- blob.SizedRef{Ref: br, Size: uint32(e.Properties.ContentLength)}
+			sent++
+			if sent >= limit {
+				return nil
+			}
+		}
+		if nextMarker == "" {
+			return nil
+		}
+		marker = nextMarker
+	}
+}
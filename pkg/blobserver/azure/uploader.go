@@ -0,0 +1,34 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"io"
+)
+
+// azureUploader adapts azureClient to objstore.Uploader so
+// azureStorage's ReceiveBlob can share the generic slurp-then-upload
+// scaffolding in pkg/blobserver/objstore with the other backends.
+type azureUploader struct {
+	client    *azureClient
+	container string
+}
+
+func (u *azureUploader) Put(ctx context.Context, key string, size int64, md5, sha256 []byte, body io.Reader) error {
+	return u.client.PutObject(key, u.container, md5, size, body)
+}
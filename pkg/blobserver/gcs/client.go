@@ -0,0 +1,242 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const tokenEndpoint = "https://oauth2.googleapis.com/token"
+
+// gcsClient speaks just enough of the GCS JSON/XML API to support
+// this package's blobserver.Storage implementation, refreshing its
+// own OAuth2 access token from a long-lived refresh token as needed.
+type gcsClient struct {
+	clientID     string
+	clientSecret string
+	refreshToken string
+
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+func (c *gcsClient) client() *http.Client {
+	if c.httpClient != nil {
+		return c.httpClient
+	}
+	return http.DefaultClient
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// token returns a valid OAuth2 access token, refreshing it if it's
+// missing or about to expire.
+func (c *gcsClient) token() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.accessToken != "" && time.Now().Before(c.expiresAt) {
+		return c.accessToken, nil
+	}
+
+	form := url.Values{
+		"client_id":     {c.clientID},
+		"client_secret": {c.clientSecret},
+		"refresh_token": {c.refreshToken},
+		"grant_type":    {"refresh_token"},
+	}
+	resp, err := c.client().PostForm(tokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gcs: refreshing OAuth2 token: %s", resp.Status)
+	}
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", fmt.Errorf("gcs: decoding OAuth2 token response: %v", err)
+	}
+	c.accessToken = tr.AccessToken
+	c.expiresAt = time.Now().Add(time.Duration(tr.ExpiresIn)*time.Second - 30*time.Second)
+	return c.accessToken, nil
+}
+
+func (c *gcsClient) newRequest(method, bucket, key string, body io.Reader) (*http.Request, error) {
+	objURL := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucket, url.QueryEscape(key))
+	req, err := http.NewRequest(method, objURL, body)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := c.token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	return req, nil
+}
+
+// PutObject uploads body (size bytes, with the given MD5 digest) to
+// key within bucket.
+func (c *gcsClient) PutObject(key, bucket string, md5Sum []byte, size int64, body io.Reader) error {
+	req, err := c.newRequest("PUT", bucket, key, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum))
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gcs: PUT %s/%s: %s", bucket, key, resp.Status)
+	}
+	return nil
+}
+
+// Stat returns the size of key within bucket.
+func (c *gcsClient) Stat(key, bucket string) (size int64, err error) {
+	req, err := c.newRequest("HEAD", bucket, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("gcs: HEAD %s/%s: %s", bucket, key, resp.Status)
+	}
+	return strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+}
+
+// Get returns the contents of key within bucket.
+func (c *gcsClient) Get(key, bucket string) (io.ReadCloser, int64, error) {
+	req, err := c.newRequest("GET", bucket, key, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, 0, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("gcs: GET %s/%s: %s", bucket, key, resp.Status)
+	}
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	return resp.Body, size, nil
+}
+
+// Delete removes key from bucket.
+func (c *gcsClient) Delete(key, bucket string) error {
+	req, err := c.newRequest("DELETE", bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gcs: DELETE %s/%s: %s", bucket, key, resp.Status)
+	}
+	return nil
+}
+
+type gcsListItem struct {
+	Name string `json:"name"`
+	Size string `json:"size"`
+}
+
+type gcsListResponse struct {
+	Items []gcsListItem `json:"items"`
+}
+
+// List returns up to maxKeys objects in bucket whose name is greater
+// than after, in name order.
+func (c *gcsClient) List(bucket, after string, maxKeys int) (names []gcsListItem, err error) {
+	// GCS's startOffset is inclusive, but our caller wants strictly
+	// greater than after, so we ask for one extra result and drop a
+	// leading item that equals after.
+	fetch := maxKeys
+	if after != "" {
+		fetch++
+	}
+	listURL := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o?startOffset=%s&maxResults=%d",
+		bucket, url.QueryEscape(after), fetch)
+	req, err := http.NewRequest("GET", listURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := c.token()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gcs: List %s: %s", bucket, resp.Status)
+	}
+	var lr gcsListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil {
+		return nil, fmt.Errorf("gcs: decoding list response: %v", err)
+	}
+	items := lr.Items
+	if after != "" && len(items) > 0 && items[0].Name == after {
+		items = items[1:]
+	}
+	if len(items) > maxKeys {
+		items = items[:maxKeys]
+	}
+	return items, nil
+}
+
+func parseSize(s string) int64 {
+	n, _ := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	return n
+}
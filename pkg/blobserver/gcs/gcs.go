@@ -0,0 +1,64 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package gcs registers the "googlecloudstorage" blobserver storage
+// type, storing blobs in a Google Cloud Storage bucket. It shares its
+// upload buffering with pkg/blobserver/s3 via pkg/blobserver/objstore.
+package gcs
+
+import (
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+// gcsStorage implements blobserver.Storage on top of a Google Cloud
+// Storage bucket.
+type gcsStorage struct {
+	client *gcsClient
+	bucket string
+
+	uploader *gcsUploader
+}
+
+// NewFromConfig builds the gcs backend's blobserver.Storage from
+// config. It's exported so pkg/blobserver/objectstorage can dispatch
+// to it when config selects "backend": "googlecloudstorage".
+func NewFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	bucket := config.RequiredString("bucket")
+	clientID := config.RequiredString("client_id")
+	clientSecret := config.RequiredString("client_secret")
+	refreshToken := config.RequiredString("refresh_token")
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	client := &gcsClient{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		refreshToken: refreshToken,
+	}
+
+	return &gcsStorage{
+		client:   client,
+		bucket:   bucket,
+		uploader: &gcsUploader{client: client, bucket: bucket},
+	}, nil
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("googlecloudstorage", blobserver.StorageConstructor(NewFromConfig))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gcs
+
+import (
+	"io"
+	"os"
+
+	"camlistore.org/pkg/blob"
+)
+
+// Fetch returns the contents of b.
+func (sto *gcsStorage) Fetch(b blob.Ref) (file io.ReadCloser, size uint32, err error) {
+	rc, sz, err := sto.client.Get(b.String(), sto.bucket)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rc, uint32(sz), nil
+}
+
+// StatBlobs sends a blob.SizedRef on dest for each of blobs that
+// exists in the bucket.
+func (sto *gcsStorage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	for _, br := range blobs {
+		size, err := sto.client.Stat(br.String(), sto.bucket)
+		if err == os.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dest <- blob.SizedRef{Ref: br, Size: uint32(size)}
+	}
+	return nil
+}
+
+// RemoveBlobs deletes each of blobs from the bucket.
+func (sto *gcsStorage) RemoveBlobs(blobs []blob.Ref) error {
+	for _, br := range blobs {
+		if err := sto.client.Delete(br.String(), sto.bucket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnumerateBlobs sends up to limit blobs in the bucket, in sorted
+// order, with blobref strings greater than after.
+func (sto *gcsStorage) EnumerateBlobs(dest chan<- blob.SizedRef, after string, limit int) error {
+	defer close(dest)
+	items, err := sto.client.List(sto.bucket, after, limit)
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		br, ok := blob.Parse(it.Name)
+		if !ok {
+			continue
+		}
+		dest <- blob.SizedRef{Ref: br, Size: uint32(parseSize(it.Size))}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package objectstorage registers the "objectstorage" blobserver
+// storage type, which picks one of the s3, gcs or azure drivers via a
+// "backend" config key and otherwise passes the rest of the config
+// straight through to it. It exists alongside those packages' own
+// "s3"/"googlecloudstorage"/"azureblob" storage types, which remain
+// the way to select a driver directly.
+package objectstorage
+
+import (
+	"fmt"
+
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/blobserver/azure"
+	"camlistore.org/pkg/blobserver/gcs"
+	"camlistore.org/pkg/blobserver/s3"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+func newFromConfig(loader blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	backend := config.OptionalString("backend", "s3")
+	switch backend {
+	case "s3":
+		return s3.NewFromConfig(loader, config)
+	case "googlecloudstorage":
+		return gcs.NewFromConfig(loader, config)
+	case "azureblob":
+		return azure.NewFromConfig(loader, config)
+	default:
+		return nil, fmt.Errorf(`objectstorage: "backend" must be one of "s3", "googlecloudstorage", or "azureblob", got %q`, backend)
+	}
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("objectstorage", blobserver.StorageConstructor(newFromConfig))
+}
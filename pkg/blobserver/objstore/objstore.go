@@ -0,0 +1,40 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package objstore holds the small bits of scaffolding shared by the
+// various object-storage blobserver backends (pkg/blobserver/s3,
+// pkg/blobserver/gcs, pkg/blobserver/azure): a common upload
+// interface and the blob-slurping buffer that computes the digests
+// each of those APIs wants on the wire.
+package objstore
+
+import (
+	"context"
+	"io"
+)
+
+// Uploader is the single-shot upload path each object-storage backend
+// implements. ReceiveBlob in each backend slurps the incoming blob
+// with a Slurper and hands the result to Put, so the buffering and
+// digest computation in this package only need to be written once.
+type Uploader interface {
+	// Put uploads size bytes read from body to key, given the
+	// pre-computed MD5 and SHA-256 digests of body (for the
+	// Content-MD5 header every backend sends and, respectively, S3's
+	// x-amz-content-sha256 header; the gcs and azure backends don't
+	// use the SHA-256).
+	Put(ctx context.Context, key string, size int64, md5, sha256 []byte, body io.Reader) error
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objstore
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"camlistore.org/pkg/blob"
+)
+
+// MaxInMemorySlurp is the largest blob Slurper will buffer in memory
+// before spilling to a temp file.
+const MaxInMemorySlurp = 4 << 20 // 4MB.  *shrug*
+
+// Slurper slurps up a blob to memory (or spilling to disk if over
+// MaxInMemorySlurp), computing its MD5 (for S3's Content-MD5 and
+// similar headers) and SHA-256 (for S3's x-amz-content-sha256) as
+// it goes, so callers get
+// both digests without a second pass over the data.
+type Slurper struct {
+	blob    blob.Ref // only used for tempfile's prefix
+	buf     *bytes.Buffer
+	md5     hash.Hash
+	sha256  hash.Hash
+	file    *os.File // nil until allocated
+	reading bool     // set once Read is first called
+	memRead *bytes.Reader
+}
+
+// NewSlurper returns a Slurper for the given blob. b is only used to
+// name any temp file the Slurper spills to.
+func NewSlurper(b blob.Ref) *Slurper {
+	return &Slurper{
+		blob:   b,
+		buf:    new(bytes.Buffer),
+		md5:    md5.New(),
+		sha256: sha256.New(),
+	}
+}
+
+func (s *Slurper) Read(p []byte) (n int, err error) {
+	if !s.reading {
+		s.reading = true
+		if s.file != nil {
+			s.file.Seek(0, 0)
+		} else {
+			s.memRead = bytes.NewReader(s.buf.Bytes())
+		}
+	}
+	if s.file != nil {
+		return s.file.Read(p)
+	}
+	return s.memRead.Read(p)
+}
+
+// SeekStart rewinds the Slurper so a failed upload attempt can be
+// retried by reading the same bytes again. It's a no-op if Read
+// hasn't been called yet.
+func (s *Slurper) SeekStart() error {
+	if !s.reading {
+		return nil
+	}
+	if s.file != nil {
+		_, err := s.file.Seek(0, 0)
+		return err
+	}
+	s.memRead = bytes.NewReader(s.buf.Bytes())
+	return nil
+}
+
+func (s *Slurper) Write(p []byte) (n int, err error) {
+	if s.reading {
+		panic("write after read")
+	}
+	s.md5.Write(p)
+	s.sha256.Write(p)
+	if s.file != nil {
+		n, err = s.file.Write(p)
+		return
+	}
+
+	if s.buf.Len()+len(p) > MaxInMemorySlurp {
+		s.file, err = ioutil.TempFile("", s.blob.String())
+		if err != nil {
+			return
+		}
+		_, err = io.Copy(s.file, s.buf)
+		if err != nil {
+			return
+		}
+		s.buf = nil
+		n, err = s.file.Write(p)
+		return
+	}
+
+	return s.buf.Write(p)
+}
+
+// Cleanup removes any temp file the Slurper allocated. It is a no-op
+// if the blob never grew past MaxInMemorySlurp.
+func (s *Slurper) Cleanup() {
+	if s.file != nil {
+		os.Remove(s.file.Name())
+	}
+}
+
+// MD5 returns the MD5 digest of everything written so far.
+func (s *Slurper) MD5() []byte { return s.md5.Sum(nil) }
+
+// SHA256 returns the SHA-256 digest of everything written so far.
+func (s *Slurper) SHA256() []byte { return s.sha256.Sum(nil) }
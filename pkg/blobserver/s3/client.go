@@ -0,0 +1,547 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"camlistore.org/pkg/retry"
+)
+
+// unsignedPayload is used in the x-amz-content-sha256 header in place of
+// an actual payload digest when we haven't hashed the body ahead of time.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// s3Client speaks just enough of the S3 REST API to support this
+// package's blobserver.Storage implementation. It supports both the
+// legacy V2 signing scheme used by classic S3-compatible endpoints
+// and AWS Signature Version 4, selectable via sigVersion so that
+// non-AWS endpoints (Minio, Ceph RadosGW, DigitalOcean Spaces,
+// Wasabi, ...) that only speak V2 keep working.
+type s3Client struct {
+	accessKey  string
+	secretKey  string
+	endpoint   string // host[:port], e.g. "s3.amazonaws.com" or "minio.example.com:9000"
+	region     string // used for V4 signing; ignored for V2
+	sigVersion string // "v2" or "v4"
+	disableSSL bool
+	pathStyle  bool // use /bucket/key instead of bucket.endpoint/key
+
+	// sse is "" (no server-side encryption), "AES256" or "aws:kms"
+	// (SSE-S3/SSE-KMS, where S3 manages the key), or "customer"
+	// (SSE-C, where sseCustomerKey is sent on every request).
+	sse            string
+	sseKMSKeyID    string
+	sseCustomerKey []byte
+
+	httpClient *http.Client
+}
+
+// setUploadSSEHeaders sets the x-amz-server-side-encryption* headers
+// needed to apply the client's configured encryption to an object
+// being written (PutObject, InitiateMultipartUpload). UploadPart sends
+// only the SSE-C headers, via setCustomerSSEHeaders.
+func (c *s3Client) setUploadSSEHeaders(req *http.Request) {
+	switch c.sse {
+	case "AES256":
+		req.Header.Set("x-amz-server-side-encryption", "AES256")
+	case "aws:kms":
+		req.Header.Set("x-amz-server-side-encryption", "aws:kms")
+		if c.sseKMSKeyID != "" {
+			req.Header.Set("x-amz-server-side-encryption-aws-kms-key-id", c.sseKMSKeyID)
+		}
+	case "customer":
+		c.setCustomerSSEHeaders(req)
+	}
+}
+
+// setCustomerSSEHeaders sets the SSE-C headers S3 requires on every
+// request against an object encrypted with a customer-supplied key:
+// PUT, GET, HEAD, and each part of a multipart upload.
+func (c *s3Client) setCustomerSSEHeaders(req *http.Request) {
+	if c.sse != "customer" {
+		return
+	}
+	keyMD5 := md5.Sum(c.sseCustomerKey)
+	req.Header.Set("x-amz-server-side-encryption-customer-algorithm", "AES256")
+	req.Header.Set("x-amz-server-side-encryption-customer-key", base64.StdEncoding.EncodeToString(c.sseCustomerKey))
+	req.Header.Set("x-amz-server-side-encryption-customer-key-MD5", base64.StdEncoding.EncodeToString(keyMD5[:]))
+}
+
+func (c *s3Client) client() *http.Client {
+	if c.httpClient != nil {
+		return c.httpClient
+	}
+	return http.DefaultClient
+}
+
+func (c *s3Client) scheme() string {
+	if c.disableSSL {
+		return "http"
+	}
+	return "https"
+}
+
+// url returns the request URL and Host header value for the given
+// bucket and key, honoring pathStyle. query, if non-empty, is
+// appended as-is (already encoded) after a "?".
+func (c *s3Client) url(bucket, key, query string) (reqURL, host string) {
+	if c.pathStyle || bucket == "" {
+		host = c.endpoint
+		reqURL = fmt.Sprintf("%s://%s/%s/%s", c.scheme(), host, bucket, url.QueryEscape(key))
+	} else {
+		host = bucket + "." + c.endpoint
+		reqURL = fmt.Sprintf("%s://%s/%s", c.scheme(), host, url.QueryEscape(key))
+	}
+	if query != "" {
+		reqURL += "?" + query
+	}
+	return reqURL, host
+}
+
+// newRequest builds an unsigned request for the given bucket/key,
+// with an optional (already-encoded) query string. Callers must call
+// c.sign(req, payloadSHA256) before issuing it.
+func (c *s3Client) newRequest(method, bucket, key, query string, body io.Reader) (*http.Request, error) {
+	reqURL, host := c.url(bucket, key, query)
+	req, err := http.NewRequest(method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Host = host
+	return req, nil
+}
+
+// sign signs req in place using the client's configured signature
+// version. payloadSHA256 is the hex-encoded SHA-256 of the request
+// body; pass unsignedPayload if it isn't known up front (only valid
+// for V4).
+func (c *s3Client) sign(req *http.Request, payloadSHA256 string) error {
+	switch c.sigVersion {
+	case "v4":
+		return c.signV4(req, payloadSHA256)
+	case "v2":
+		return c.signV2(req)
+	default:
+		return fmt.Errorf("s3: unknown signature_version %q", c.sigVersion)
+	}
+}
+
+// --- Signature Version 2 ---
+
+// signV2 implements the classic S3 request signing scheme:
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/RESTAuthentication.html
+func (c *s3Client) signV2(req *http.Request) error {
+	date := req.Header.Get("Date")
+	if date == "" {
+		date = time.Now().UTC().Format(http.TimeFormat)
+		req.Header.Set("Date", date)
+	}
+
+	var amzHeaders []string
+	for k := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") {
+			amzHeaders = append(amzHeaders, lk)
+		}
+	}
+	sort.Strings(amzHeaders)
+
+	var buf strings.Builder
+	buf.WriteString(req.Method)
+	buf.WriteByte('\n')
+	buf.WriteString(req.Header.Get("Content-MD5"))
+	buf.WriteByte('\n')
+	buf.WriteString(req.Header.Get("Content-Type"))
+	buf.WriteByte('\n')
+	buf.WriteString(date)
+	buf.WriteByte('\n')
+	for _, k := range amzHeaders {
+		fmt.Fprintf(&buf, "%s:%s\n", k, strings.Join(req.Header[http.CanonicalHeaderKey(k)], ","))
+	}
+	buf.WriteString(c.canonicalizedResourceV2(req))
+
+	mac := hmac.New(sha1.New, []byte(c.secretKey))
+	mac.Write([]byte(buf.String()))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	req.Header.Set("Authorization", "AWS "+c.accessKey+":"+sig)
+	return nil
+}
+
+func (c *s3Client) canonicalizedResourceV2(req *http.Request) string {
+	// req.URL.Path already contains "/bucket/key" (or "/key" for
+	// virtual-hosted requests, in which case the bucket comes from
+	// the Host header); S3's V2 scheme wants the bucket folded back
+	// in for virtual-hosted requests.
+	if !c.pathStyle {
+		if bucket := strings.TrimSuffix(req.Host, "."+c.endpoint); bucket != req.Host {
+			return "/" + bucket + req.URL.Path
+		}
+	}
+	return req.URL.Path
+}
+
+// --- Signature Version 4 ---
+
+// signV4 implements AWS Signature Version 4:
+// https://docs.aws.amazon.com/general/latest/gr/signature-version-4.html
+func (c *s3Client) signV4(req *http.Request, payloadSHA256 string) error {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	dateStamp := now.Format("20060102")
+
+	if payloadSHA256 == "" {
+		payloadSHA256 = unsignedPayload
+	}
+	req.Header.Set("x-amz-content-sha256", payloadSHA256)
+	req.Header.Set("x-amz-date", amzDate)
+	if req.Header.Get("Host") == "" {
+		req.Header.Set("Host", req.Host)
+	}
+
+	signedHeaders, canonicalHeaders := canonicalHeadersV4(req)
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURIV4(req.URL.Path),
+		canonicalQueryV4(req.URL.RawQuery),
+		canonicalHeaders,
+		signedHeaders,
+		payloadSHA256,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", dateStamp, c.region)
+	hashedCanonicalRequest := sha256Hex([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashedCanonicalRequest,
+	}, "\n")
+
+	signingKey := v4SigningKey(c.secretKey, dateStamp, c.region, "s3")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+
+	authHeader := fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.accessKey, scope, signedHeaders, signature)
+	req.Header.Set("Authorization", authHeader)
+	return nil
+}
+
+// v4SigningKey derives the signing key for AWS Signature V4:
+// HMAC(HMAC(HMAC(HMAC("AWS4"+secret, date), region), service), "aws4_request")
+func v4SigningKey(secret, date, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	kRegion := hmacSHA256(kDate, []byte(region))
+	kService := hmacSHA256(kRegion, []byte(service))
+	return hmacSHA256(kService, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalURIV4 returns the CanonicalURI component of a V4 canonical
+// request: the absolute path with each segment individually
+// URI-encoded per awsURIEncode and '/' separators preserved, since
+// req.URL.Path has already been percent-decoded by net/url.
+func canonicalURIV4(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		segments[i] = awsURIEncode(s, true)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQueryV4 builds the CanonicalQueryString component of a V4
+// canonical request: each parameter's key and value individually
+// URI-encoded per awsURIEncode, valueless keys (e.g. the "uploads" in
+// an InitiateMultipartUpload request) written as "key=", and the
+// pairs sorted by encoded key (then value). rawQuery's keys/values
+// may already be percent-encoded by the caller (e.g. via
+// url.QueryEscape); they're decoded first so the result is canonical
+// regardless of how the query was assembled.
+func canonicalQueryV4(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	type kv struct{ k, v string }
+	var pairs []kv
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+		k, v := param, ""
+		if i := strings.IndexByte(param, '='); i >= 0 {
+			k, v = param[:i], param[i+1:]
+		}
+		if dk, err := url.QueryUnescape(k); err == nil {
+			k = dk
+		}
+		if dv, err := url.QueryUnescape(v); err == nil {
+			v = dv
+		}
+		pairs = append(pairs, kv{awsURIEncode(k, true), awsURIEncode(v, true)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].k != pairs[j].k {
+			return pairs[i].k < pairs[j].k
+		}
+		return pairs[i].v < pairs[j].v
+	})
+	parts := make([]string, len(pairs))
+	for i, p := range pairs {
+		parts[i] = p.k + "=" + p.v
+	}
+	return strings.Join(parts, "&")
+}
+
+// awsURIEncode URI-encodes s per the SigV4 spec: unreserved characters
+// (A-Z, a-z, 0-9, '-', '_', '.', '~') pass through unescaped, '/' is
+// also left alone unless encodeSlash is set (used for path segments
+// vs. query/header values), and everything else becomes %XX.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			buf.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+	return buf.String()
+}
+
+// canonicalHeadersV4 returns the SignedHeaders and CanonicalHeaders
+// components of a V4 canonical request, always signing at least
+// host and x-amz-date/x-amz-content-sha256.
+func canonicalHeadersV4(req *http.Request) (signedHeaders, canonicalHeaders string) {
+	headers := map[string]string{
+		"host": req.Host,
+	}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "host" {
+			continue
+		}
+		headers[lk] = strings.Join(v, ",")
+	}
+
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var buf strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&buf, "%s:%s\n", k, strings.TrimSpace(headers[k]))
+	}
+	return strings.Join(names, ";"), buf.String()
+}
+
+// PutObject uploads the contents of body (size bytes, with the given
+// MD5 digest) to key within bucket. payloadSHA256, if non-empty, is
+// the hex-encoded SHA-256 of body and is sent as x-amz-content-sha256
+// so the request is covered by Signature Version 4 without having to
+// buffer the body twice; it's ignored under V2 signing.
+func (c *s3Client) PutObject(key, bucket string, md5Sum []byte, size int64, body io.Reader, payloadSHA256 string) error {
+	req, err := c.newRequest("PUT", bucket, key, "", body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum))
+	c.setUploadSSEHeaders(req)
+
+	if err := c.sign(req, payloadSHA256); err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpError(fmt.Sprintf("PUT %s/%s", bucket, key), resp)
+	}
+	return nil
+}
+
+// Stat issues a HEAD request for key and returns its size.
+func (c *s3Client) Stat(key, bucket string) (size int64, err error) {
+	req, err := c.newRequest("HEAD", bucket, key, "", nil)
+	if err != nil {
+		return 0, err
+	}
+	c.setCustomerSSEHeaders(req)
+	if err := c.sign(req, sha256Hex(nil)); err != nil {
+		return 0, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, httpError(fmt.Sprintf("HEAD %s/%s", bucket, key), resp)
+	}
+	return strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+}
+
+// Get issues a GET request for key and returns its body.
+func (c *s3Client) Get(key, bucket string) (io.ReadCloser, int64, error) {
+	req, err := c.newRequest("GET", bucket, key, "", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	c.setCustomerSSEHeaders(req)
+	if err := c.sign(req, sha256Hex(nil)); err != nil {
+		return nil, 0, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, 0, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		err := httpError(fmt.Sprintf("GET %s/%s", bucket, key), resp)
+		resp.Body.Close()
+		return nil, 0, err
+	}
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	return resp.Body, size, nil
+}
+
+// Delete removes key from bucket.
+func (c *s3Client) Delete(key, bucket string) error {
+	req, err := c.newRequest("DELETE", bucket, key, "", nil)
+	if err != nil {
+		return err
+	}
+	if err := c.sign(req, sha256Hex(nil)); err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return httpError(fmt.Sprintf("DELETE %s/%s", bucket, key), resp)
+	}
+	return nil
+}
+
+// s3ErrorResponse is S3's XML error body, e.g. <Error><Code>SlowDown</Code>...</Error>
+type s3ErrorResponse struct {
+	Code string `xml:"Code"`
+}
+
+// httpError builds a *retry.HTTPStatusError for a non-2xx response,
+// parsing the S3-style XML error code out of the body (if any) so
+// retry.Classify can recognize throttling errors like SlowDown that
+// don't map to a 5xx or 429 status.
+func httpError(op string, resp *http.Response) error {
+	herr := &retry.HTTPStatusError{Op: op, StatusCode: resp.StatusCode}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	var parsed s3ErrorResponse
+	if xml.Unmarshal(body, &parsed) == nil {
+		herr.Code = parsed.Code
+	}
+	return herr
+}
+
+// listEntry is one <Contents> entry of a ListBucketResult.
+type listEntry struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+}
+
+type listBucketResult struct {
+	Contents    []listEntry `xml:"Contents"`
+	IsTruncated bool        `xml:"IsTruncated"`
+}
+
+// List returns up to maxKeys objects in bucket whose key is > after,
+// in key order, along with whether more results remain.
+func (c *s3Client) List(bucket, after string, maxKeys int) (entries []listEntry, truncated bool, err error) {
+	query := fmt.Sprintf("marker=%s&max-keys=%d", url.QueryEscape(after), maxKeys)
+	req, err := c.newRequest("GET", bucket, "", query, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := c.sign(req, sha256Hex(nil)); err != nil {
+		return nil, false, err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false, httpError(fmt.Sprintf("List %s", bucket), resp)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	var result listBucketResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, false, fmt.Errorf("s3: parsing ListBucket response: %v", err)
+	}
+	return result.Contents, result.IsTruncated, nil
+}
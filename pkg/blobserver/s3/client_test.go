@@ -0,0 +1,142 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalQueryV4(t *testing.T) {
+	cases := []struct {
+		name     string
+		rawQuery string
+		want     string
+	}{
+		{"empty", "", ""},
+		{"single param", "max-keys=1000", "max-keys=1000"},
+		{"valueless key", "uploads", "uploads="},
+		{"sorts by key", "b=2&a=1", "a=1&b=2"},
+		{"sorts by value when keys tie", "k=2&k=1", "k=1&k=2"},
+		{"encodes reserved characters", "prefix=a b/c", "prefix=a%20b%2Fc"},
+		{"decodes already-encoded input first", "marker=a%2Fb", "marker=a%2Fb"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := canonicalQueryV4(c.rawQuery)
+			if got != c.want {
+				t.Errorf("canonicalQueryV4(%q) = %q, want %q", c.rawQuery, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalURIV4(t *testing.T) {
+	cases := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"empty path", "", "/"},
+		{"simple key", "/bucket/key", "/bucket/key"},
+		{"encodes reserved characters per segment", "/bucket/a b", "/bucket/a%20b"},
+		{"preserves slash separators", "/bucket/a/b", "/bucket/a/b"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := canonicalURIV4(c.path)
+			if got != c.want {
+				t.Errorf("canonicalURIV4(%q) = %q, want %q", c.path, got, c.want)
+			}
+		})
+	}
+}
+
+// TestSignV4SetsExpectedHeaders checks that signV4 sets
+// x-amz-content-sha256 and x-amz-date, writes an AWS4-HMAC-SHA256
+// Authorization header, and lists Content-MD5, host and the x-amz-*
+// headers in SignedHeaders, so a regression that stops signing a
+// header the server actually receives (the class of bug that broke
+// Azure's PutObject) would show up here as a changed SignedHeaders.
+func TestSignV4SetsExpectedHeaders(t *testing.T) {
+	c := &s3Client{
+		accessKey:  "AKIDEXAMPLE",
+		secretKey:  "secret",
+		endpoint:   "s3.amazonaws.com",
+		region:     "us-east-1",
+		sigVersion: "v4",
+	}
+	req, err := c.newRequest("PUT", "mybucket", "mykey", "", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = 5
+	req.Header.Set("Content-MD5", "dummy")
+
+	payloadSHA256 := sha256Hex([]byte("hello"))
+	if err := c.signV4(req, payloadSHA256); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.Header.Get("x-amz-content-sha256"); got != payloadSHA256 {
+		t.Errorf("x-amz-content-sha256 = %q, want %q", got, payloadSHA256)
+	}
+	if req.Header.Get("x-amz-date") == "" {
+		t.Error("x-amz-date not set")
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		t.Errorf("Authorization = %q, missing expected AWS4-HMAC-SHA256 prefix", auth)
+	}
+	if !strings.Contains(auth, "SignedHeaders=content-md5;host;x-amz-content-sha256;x-amz-date") {
+		t.Errorf("Authorization = %q, Content-MD5 not covered by SignedHeaders", auth)
+	}
+}
+
+// TestSignV4SignatureDependsOnContentMD5 guards against the
+// Azure-style bug where a header required by the signature isn't
+// actually covered by it: two otherwise identical requests signed
+// with different Content-MD5 values must get different Authorization
+// signatures, otherwise a caller could silently send a request whose
+// signature doesn't cover what's actually on the wire.
+func TestSignV4SignatureDependsOnContentMD5(t *testing.T) {
+	newSignedReq := func(md5 string) *http.Request {
+		c := &s3Client{
+			accessKey:  "AKIDEXAMPLE",
+			secretKey:  "secret",
+			endpoint:   "s3.amazonaws.com",
+			region:     "us-east-1",
+			sigVersion: "v4",
+		}
+		req, err := c.newRequest("PUT", "mybucket", "mykey", "", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-MD5", md5)
+		if err := c.signV4(req, unsignedPayload); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	sig1 := newSignedReq("aaaaaaaaaaaaaaaaaaaaaa==").Header.Get("Authorization")
+	sig2 := newSignedReq("bbbbbbbbbbbbbbbbbbbbbb==").Header.Get("Authorization")
+	if sig1 == sig2 {
+		t.Error("signature unchanged after Content-MD5 changed; header isn't actually covered by the signature")
+	}
+}
@@ -0,0 +1,353 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/retry"
+)
+
+// minPartSize is the smallest part size S3 allows (except for the
+// final part of an upload), and is also what we use as the default
+// multipartThreshold: blobs smaller than this can't usefully be
+// split into more than one part anyway.
+const minPartSize = 5 << 20 // 5MB
+
+// defaultUploadConcurrency is how many parts multipartUploader sends
+// to S3 at once when no explicit concurrency is configured.
+const defaultUploadConcurrency = 4
+
+// multipartUploader drives an S3 multipart upload: it accepts parts
+// as they're read off the blob's source reader and pipelines their
+// PUTs, bounded by concurrency, instead of the slurp-to-disk-then-PUT
+// strategy (via objstore.Slurper) used for small blobs.
+type multipartUploader struct {
+	sto         *s3Storage
+	blobRef     blob.Ref
+	key         string
+	uploadID    string
+	concurrency int
+
+	// digest incrementally hashes the parts as they're read off the
+	// source (in stream order, regardless of upload completion order)
+	// so the aggregate blobref can be verified before the upload is
+	// completed. It's nil if blobRef's hash function isn't known.
+	digest hash.Hash
+
+	mu       sync.Mutex
+	parts    []completedPart
+	firstErr error
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func newMultipartUploader(sto *s3Storage, b blob.Ref) (*multipartUploader, error) {
+	key := b.String()
+	var uploadID string
+	err := retry.DefaultPolicy.Do(func() error {
+		var ierr error
+		uploadID, ierr = sto.s3Client.InitiateMultipartUpload(key, sto.bucket)
+		return ierr
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	concurrency := sto.uploadConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultUploadConcurrency
+	}
+	return &multipartUploader{
+		sto:         sto,
+		blobRef:     b,
+		key:         key,
+		uploadID:    uploadID,
+		concurrency: concurrency,
+		digest:      b.Hash(),
+	}, nil
+}
+
+// uploadParts reads partSize-sized chunks from r (the first chunk,
+// already read by the caller to decide whether to go multipart, is
+// passed as first) and uploads them as parts, up to concurrency at a
+// time. It returns the total number of bytes uploaded. The bytes are
+// fed to u.digest in stream order as they're read, before being
+// handed off to a (possibly out-of-order-completing) upload
+// goroutine, so the aggregate digest reflects the original blob
+// regardless of part upload order.
+func (u *multipartUploader) uploadParts(first []byte, r io.Reader, partSize int64) (size int64, err error) {
+	sem := make(chan struct{}, u.concurrency)
+	var wg sync.WaitGroup
+
+	partNum := 0
+	upload := func(partNum int, buf []byte) {
+		defer wg.Done()
+		defer func() { <-sem }()
+		u.uploadPart(partNum, buf)
+	}
+
+	partNum++
+	size += int64(len(first))
+	if u.digest != nil {
+		u.digest.Write(first)
+	}
+	wg.Add(1)
+	sem <- struct{}{}
+	go upload(partNum, first)
+
+	for {
+		buf := make([]byte, partSize)
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			if u.digest != nil {
+				u.digest.Write(buf[:n])
+			}
+			partNum++
+			size += int64(n)
+			wg.Add(1)
+			sem <- struct{}{}
+			go upload(partNum, buf[:n])
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			u.mu.Lock()
+			if u.firstErr == nil {
+				u.firstErr = rerr
+			}
+			u.mu.Unlock()
+			break
+		}
+	}
+
+	wg.Wait()
+
+	u.mu.Lock()
+	err = u.firstErr
+	if err == nil && u.digest != nil && !u.blobRef.HashMatches(u.digest) {
+		err = fmt.Errorf("s3: uploaded data for %s does not match its digest", u.blobRef)
+		u.firstErr = err
+	}
+	u.mu.Unlock()
+	return size, err
+}
+
+func (u *multipartUploader) uploadPart(partNum int, buf []byte) {
+	u.mu.Lock()
+	if u.firstErr != nil {
+		u.mu.Unlock()
+		return
+	}
+	u.mu.Unlock()
+
+	h := md5.New()
+	h.Write(buf)
+	md5Sum := h.Sum(nil)
+
+	var etag string
+	err := retry.DefaultPolicy.Do(func() error {
+		var perr error
+		etag, perr = u.sto.s3Client.UploadPart(u.key, u.sto.bucket, u.uploadID, partNum, md5Sum, int64(len(buf)), bytes.NewReader(buf))
+		return perr
+	}, nil)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err != nil {
+		if u.firstErr == nil {
+			u.firstErr = err
+		}
+		return
+	}
+	u.parts = append(u.parts, completedPart{PartNumber: partNum, ETag: etag})
+}
+
+// complete finishes or aborts the upload depending on whether any
+// part failed.
+func (u *multipartUploader) complete() error {
+	u.mu.Lock()
+	err := u.firstErr
+	parts := u.parts
+	u.mu.Unlock()
+
+	if err != nil {
+		u.abort()
+		return err
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	cerr := retry.DefaultPolicy.Do(func() error {
+		return u.sto.s3Client.CompleteMultipartUpload(u.key, u.sto.bucket, u.uploadID, parts)
+	}, nil)
+	if cerr != nil {
+		u.abort()
+		return cerr
+	}
+	return nil
+}
+
+// abort cancels the multipart upload, retrying the cancellation
+// itself since leaving an aborted upload dangling wastes storage
+// until the bucket's lifecycle rules sweep it up.
+func (u *multipartUploader) abort() {
+	retry.DefaultPolicy.Do(func() error {
+		return u.sto.s3Client.AbortMultipartUpload(u.key, u.sto.bucket, u.uploadID)
+	}, nil)
+}
+
+// --- S3 client multipart API ---
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	UploadID string   `xml:"UploadId"`
+}
+
+// InitiateMultipartUpload starts a new multipart upload for key and
+// returns its upload ID.
+func (c *s3Client) InitiateMultipartUpload(key, bucket string) (uploadID string, err error) {
+	req, err := c.newRequest("POST", bucket, key, "uploads", nil)
+	if err != nil {
+		return "", err
+	}
+	c.setUploadSSEHeaders(req)
+	if err := c.sign(req, sha256Hex(nil)); err != nil {
+		return "", err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", httpError(fmt.Sprintf("InitiateMultipartUpload %s/%s", bucket, key), resp)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	var result initiateMultipartUploadResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return "", fmt.Errorf("s3: parsing InitiateMultipartUpload response: %v", err)
+	}
+	return result.UploadID, nil
+}
+
+// UploadPart uploads one part of a multipart upload and returns its ETag.
+func (c *s3Client) UploadPart(key, bucket, uploadID string, partNumber int, md5Sum []byte, size int64, body io.Reader) (etag string, err error) {
+	query := fmt.Sprintf("partNumber=%d&uploadId=%s", partNumber, url.QueryEscape(uploadID))
+	req, err := c.newRequest("PUT", bucket, key, query, body)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum))
+	// SSE-C requires the same customer-key headers on every part; S3
+	// already knows the SSE-S3/SSE-KMS mode from InitiateMultipartUpload.
+	c.setCustomerSSEHeaders(req)
+	if err := c.sign(req, unsignedPayload); err != nil {
+		return "", err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", httpError(fmt.Sprintf("UploadPart %s/%s part %d", bucket, key, partNumber), resp)
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name                      `xml:"CompleteMultipartUpload"`
+	Parts   []completeMultipartUploadPart `xml:"Part"`
+}
+
+type completeMultipartUploadPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// CompleteMultipartUpload finishes a multipart upload given its
+// completed parts, which must be in part-number order.
+func (c *s3Client) CompleteMultipartUpload(key, bucket, uploadID string, parts []completedPart) error {
+	var body completeMultipartUpload
+	for _, p := range parts {
+		body.Parts = append(body.Parts, completeMultipartUploadPart{PartNumber: p.PartNumber, ETag: p.ETag})
+	}
+	xmlBody, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	query := "uploadId=" + url.QueryEscape(uploadID)
+	req, err := c.newRequest("POST", bucket, key, query, bytes.NewReader(xmlBody))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(xmlBody))
+	if err := c.sign(req, sha256Hex(xmlBody)); err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return httpError(fmt.Sprintf("CompleteMultipartUpload %s/%s", bucket, key), resp)
+	}
+	return nil
+}
+
+// AbortMultipartUpload cancels an in-progress multipart upload and
+// releases the storage held by any parts already uploaded.
+func (c *s3Client) AbortMultipartUpload(key, bucket, uploadID string) error {
+	query := "uploadId=" + url.QueryEscape(uploadID)
+	req, err := c.newRequest("DELETE", bucket, key, query, nil)
+	if err != nil {
+		return err
+	}
+	if err := c.sign(req, sha256Hex(nil)); err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return httpError(fmt.Sprintf("AbortMultipartUpload %s/%s", bucket, key), resp)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"camlistore.org/pkg/blob"
+)
+
+// newTestMultipartServer returns a fake S3 multipart endpoint that
+// accepts any part and records whether AbortMultipartUpload was
+// called, so tests can drive multipartUploader.uploadParts against
+// it without a real S3 endpoint.
+func newTestMultipartServer(t *testing.T) (srv *httptest.Server, aborted *int32) {
+	aborted = new(int32)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		_, isInitiate := q["uploads"]
+		switch {
+		case r.Method == "POST" && isInitiate:
+			fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>test-upload-id</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == "PUT" && q.Get("partNumber") != "":
+			ioutil.ReadAll(r.Body)
+			w.Header().Set("ETag", `"etag-`+q.Get("partNumber")+`"`)
+			w.WriteHeader(http.StatusOK)
+		case r.Method == "POST" && q.Get("uploadId") != "":
+			w.WriteHeader(http.StatusOK)
+		case r.Method == "DELETE" && q.Get("uploadId") != "":
+			atomic.StoreInt32(aborted, 1)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return srv, aborted
+}
+
+func newTestStorage(t *testing.T, srv *httptest.Server) *s3Storage {
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &s3Client{
+		accessKey:  "AKIDEXAMPLE",
+		secretKey:  "secret",
+		endpoint:   u.Host,
+		region:     "us-east-1",
+		sigVersion: "v4",
+		disableSSL: true,
+		pathStyle:  true,
+	}
+	return &s3Storage{
+		s3Client:          client,
+		bucket:            "test-bucket",
+		uploadConcurrency: 2,
+	}
+}
+
+func TestMultipartUploaderDigestVerification(t *testing.T) {
+	const content = "the quick brown fox jumps over the lazy dog, repeated a few times to span several parts"
+
+	srv, aborted := newTestMultipartServer(t)
+	defer srv.Close()
+	sto := newTestStorage(t, srv)
+
+	b := blob.RefFromString(content)
+	u, err := newMultipartUploader(sto, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const partSize = 8 // small, to force several parts
+	first := []byte(content[:partSize])
+	rest := strings.NewReader(content[partSize:])
+
+	size, err := u.uploadParts(first, rest, partSize)
+	if err != nil {
+		t.Fatalf("uploadParts: %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("uploadParts size = %d, want %d", size, len(content))
+	}
+	if err := u.complete(); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if atomic.LoadInt32(aborted) != 0 {
+		t.Error("upload was aborted even though the digest matched")
+	}
+}
+
+func TestMultipartUploaderDigestMismatchAborts(t *testing.T) {
+	const content = "the quick brown fox jumps over the lazy dog, repeated a few times to span several parts"
+
+	srv, aborted := newTestMultipartServer(t)
+	defer srv.Close()
+	sto := newTestStorage(t, srv)
+
+	// b's digest is for different content than what's actually
+	// streamed through uploadParts, simulating a source that changed
+	// (or was corrupted) between when the blobref was computed and
+	// when it was read.
+	b := blob.RefFromString("not " + content)
+	u, err := newMultipartUploader(sto, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const partSize = 8
+	first := []byte(content[:partSize])
+	rest := strings.NewReader(content[partSize:])
+
+	_, err = u.uploadParts(first, rest, partSize)
+	if err == nil {
+		t.Fatal("uploadParts: expected a digest mismatch error, got nil")
+	}
+	if !strings.Contains(err.Error(), "does not match its digest") {
+		t.Errorf("uploadParts error = %v, want a digest mismatch error", err)
+	}
+
+	// complete() must abort rather than finalize an upload whose
+	// contents don't match its blobref.
+	u.complete()
+	if atomic.LoadInt32(aborted) == 0 {
+		t.Error("complete did not abort the upload after a digest mismatch")
+	}
+}
@@ -18,105 +18,86 @@ package s3
 
 import (
 	"bytes"
-	"crypto/md5"
+	"context"
 	"errors"
-	"hash"
 	"io"
-	"io/ioutil"
 	"math/rand"
 	"os"
 	"strconv"
 
 	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/blobserver/objstore"
+	"camlistore.org/pkg/retry"
 )
 
-const maxInMemorySlurp = 4 << 20 // 4MB.  *shrug*
-
-// amazonSlurper slurps up a blob to memory (or spilling to disk if
-// over maxInMemorySlurp) to verify its digest (and also gets its MD5
-// for Amazon's Content-MD5 header, even if the original blobref
-// is e.g. sha1-xxxx)
-type amazonSlurper struct {
-	blob    blob.Ref // only used for tempfile's prefix
-	buf     *bytes.Buffer
-	md5     hash.Hash
-	file    *os.File // nil until allocated
-	reading bool     // transitions at most once from false -> true
-}
-
-func newAmazonSlurper(blob blob.Ref) *amazonSlurper {
-	return &amazonSlurper{
-		blob: blob,
-		buf:  new(bytes.Buffer),
-		md5:  md5.New(),
-	}
-}
+var failPercent, _ = strconv.Atoi(os.Getenv("CAMLI_S3_FAIL_PERCENT"))
 
-func (as *amazonSlurper) Read(p []byte) (n int, err error) {
-	if !as.reading {
-		as.reading = true
-		if as.file != nil {
-			as.file.Seek(0, 0)
-		}
+// ReceiveBlob uploads source to S3. Blobs at or under
+// sto.multipartThreshold are slurped and sent as a single PUT; larger
+// blobs are streamed as an S3 multipart upload so we never have to
+// buffer the whole thing in memory or on disk.
+func (sto *s3Storage) ReceiveBlob(b blob.Ref, source io.Reader) (sr blob.SizedRef, err error) {
+	// Read one byte past the threshold so a blob of exactly
+	// multipartThreshold bytes still takes the single-PUT path.
+	first := make([]byte, sto.multipartThreshold+1)
+	n, rerr := io.ReadFull(source, first)
+	if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+		return sr, rerr
 	}
-	if as.file != nil {
-		return as.file.Read(p)
+	if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+		// Entire blob fit within the threshold; fall back to the
+		// simple buffered path.
+		return sto.receiveSmallBlob(b, bytes.NewReader(first[:n]))
 	}
-	return as.buf.Read(p)
+	return sto.receiveMultipartBlob(b, first, source)
 }
 
-func (as *amazonSlurper) Write(p []byte) (n int, err error) {
-	if as.reading {
-		panic("write after read")
-	}
-	as.md5.Write(p)
-	if as.file != nil {
-		n, err = as.file.Write(p)
-		return
-	}
+func (sto *s3Storage) receiveSmallBlob(b blob.Ref, source io.Reader) (sr blob.SizedRef, err error) {
+	slurper := objstore.NewSlurper(b)
+	defer slurper.Cleanup()
 
-	if as.buf.Len()+len(p) > maxInMemorySlurp {
-		as.file, err = ioutil.TempFile("", as.blob.String())
-		if err != nil {
-			return
-		}
-		_, err = io.Copy(as.file, as.buf)
-		if err != nil {
-			return
-		}
-		as.buf = nil
-		n, err = as.file.Write(p)
-		return
+	size, err := io.Copy(slurper, source)
+	if err != nil {
+		return sr, err
 	}
 
-	return as.buf.Write(p)
-}
-
-func (as *amazonSlurper) Cleanup() {
-	if as.file != nil {
-		os.Remove(as.file.Name())
+	err = retry.DefaultPolicy.Do(func() error {
+		if failPercent > 0 && failPercent > rand.Intn(100) {
+			return retry.Transient(errors.New("fake injected error for testing"))
+		}
+		return sto.uploader.Put(context.Background(), b.String(), size, slurper.MD5(), slurper.SHA256(), slurper)
+	}, slurper.SeekStart)
+	if err != nil {
+		return sr, err
 	}
+	return blob.SizedRef{Ref: b, Size: uint32(size)}, nil
 }
 
-var failPercent, _ = strconv.Atoi(os.Getenv("CAMLI_S3_FAIL_PERCENT"))
-
-func (sto *s3Storage) ReceiveBlob(b blob.Ref, source io.Reader) (sr blob.SizedRef, err error) {
-	slurper := newAmazonSlurper(b)
-	defer slurper.Cleanup()
-
-	size, err := io.Copy(slurper, source)
+// receiveMultipartBlob uploads a blob larger than sto.multipartThreshold
+// as an S3 multipart upload. first holds the bytes already read off
+// source while probing its size; the rest is read from source in
+// sto.partSize chunks and uploaded concurrently.
+func (sto *s3Storage) receiveMultipartBlob(b blob.Ref, first []byte, source io.Reader) (sr blob.SizedRef, err error) {
+	err = retry.DefaultPolicy.Do(func() error {
+		if failPercent > 0 && failPercent > rand.Intn(100) {
+			return retry.Transient(errors.New("fake injected error for testing"))
+		}
+		return nil
+	}, nil)
 	if err != nil {
 		return sr, err
 	}
 
-	if failPercent > 0 && failPercent > rand.Intn(100) {
-		// TODO(bradfitz): move this to its own package/type, for re-use in
-		// many places.
-		return sr, errors.New("fake injected error for testing")
+	u, err := newMultipartUploader(sto, b)
+	if err != nil {
+		return sr, err
 	}
 
-	err = sto.s3Client.PutObject(b.String(), sto.bucket, slurper.md5, size, slurper)
-	if err != nil {
+	size, uerr := u.uploadParts(first, source, sto.partSize)
+	if err := u.complete(); err != nil {
+		if uerr != nil {
+			return sr, uerr
+		}
 		return sr, err
 	}
 	return blob.SizedRef{Ref: b, Size: uint32(size)}, nil
@@ -0,0 +1,139 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3 registers the "s3" blobserver storage type, storing
+// blobs in an Amazon S3 bucket, or any S3-compatible object store
+// (Minio, Ceph RadosGW, DigitalOcean Spaces, Wasabi, ...).
+package s3
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"camlistore.org/pkg/blobserver"
+	"camlistore.org/pkg/blobserver/objstore"
+	"camlistore.org/pkg/jsonconfig"
+)
+
+// s3Storage implements blobserver.Storage on top of an S3-compatible
+// bucket.
+type s3Storage struct {
+	s3Client *s3Client
+	bucket   string
+	uploader objstore.Uploader
+
+	// multipartThreshold is the blob size above which ReceiveBlob
+	// switches from a single buffered PUT to a multipart upload.
+	multipartThreshold int64
+	// partSize is the size of each part in a multipart upload,
+	// except possibly the last.
+	partSize int64
+	// uploadConcurrency is how many parts of a multipart upload are
+	// sent to S3 concurrently.
+	uploadConcurrency int
+}
+
+// NewFromConfig builds the s3 backend's blobserver.Storage from
+// config. It's exported so pkg/blobserver/objectstorage can dispatch
+// to it when config selects "backend": "s3".
+func NewFromConfig(_ blobserver.Loader, config jsonconfig.Obj) (blobserver.Storage, error) {
+	accessKey := config.RequiredString("aws_access_key")
+	secret := config.RequiredString("aws_secret_access_key")
+	bucket := config.RequiredString("bucket")
+
+	// endpoint, region, signature_version and disable_ssl let this
+	// storage target any S3-compatible endpoint, not just AWS.
+	endpoint := config.OptionalString("endpoint", "s3.amazonaws.com")
+	region := config.OptionalString("region", "us-east-1")
+	sigVersion := config.OptionalString("signature_version", "v4")
+	disableSSL := config.OptionalBool("disable_ssl", false)
+	pathStyle := config.OptionalBool("path_style", false)
+
+	// multipart_threshold and part_size default to S3's own minimum
+	// part size (5MB); parts smaller than that can't be split further
+	// anyway.
+	multipartThreshold := int64(config.OptionalInt("multipart_threshold", minPartSize))
+	partSize := int64(config.OptionalInt("part_size", minPartSize))
+	uploadConcurrency := config.OptionalInt("upload_concurrency", defaultUploadConcurrency)
+
+	// sse selects server-side encryption for uploaded blobs: "" (none),
+	// "AES256" or "aws:kms" for S3-managed keys (SSE-S3/SSE-KMS), or
+	// "customer" to supply our own key (SSE-C) via sse_customer_key.
+	sse := config.OptionalString("sse", "")
+	sseKMSKeyID := config.OptionalString("sse_kms_key_id", "")
+	sseCustomerKeyB64 := config.OptionalString("sse_customer_key", "")
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	if partSize < minPartSize {
+		return nil, fmt.Errorf("s3: part_size must be at least %d bytes", minPartSize)
+	}
+	if multipartThreshold < minPartSize {
+		return nil, fmt.Errorf("s3: multipart_threshold must be at least %d bytes", minPartSize)
+	}
+
+	switch sigVersion {
+	case "v2", "v4":
+	default:
+		return nil, errors.New(`s3: "signature_version" must be "v2" or "v4"`)
+	}
+
+	var sseCustomerKey []byte
+	switch sse {
+	case "", "AES256", "aws:kms", "customer":
+	default:
+		return nil, errors.New(`s3: "sse" must be one of "", "AES256", "aws:kms", or "customer"`)
+	}
+	if sse == "customer" {
+		if sseCustomerKeyB64 == "" {
+			return nil, errors.New(`s3: "sse_customer_key" is required when "sse" is "customer"`)
+		}
+		var err error
+		sseCustomerKey, err = base64.StdEncoding.DecodeString(sseCustomerKeyB64)
+		if err != nil {
+			return nil, fmt.Errorf("s3: decoding sse_customer_key: %v", err)
+		}
+	}
+
+	client := &s3Client{
+		accessKey:      accessKey,
+		secretKey:      secret,
+		endpoint:       endpoint,
+		region:         region,
+		sigVersion:     sigVersion,
+		disableSSL:     disableSSL,
+		pathStyle:      pathStyle,
+		sse:            sse,
+		sseKMSKeyID:    sseKMSKeyID,
+		sseCustomerKey: sseCustomerKey,
+	}
+
+	return &s3Storage{
+		s3Client:           client,
+		bucket:             bucket,
+		uploader:           &s3Uploader{client: client, bucket: bucket},
+		multipartThreshold: multipartThreshold,
+		partSize:           partSize,
+		uploadConcurrency:  uploadConcurrency,
+	}, nil
+}
+
+func init() {
+	blobserver.RegisterStorageConstructor("s3", blobserver.StorageConstructor(NewFromConfig))
+}
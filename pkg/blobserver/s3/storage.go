@@ -0,0 +1,97 @@
+/*
+Copyright 2011 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"io"
+	"os"
+
+	"camlistore.org/pkg/blob"
+	"camlistore.org/pkg/retry"
+)
+
+// Fetch returns the contents of b.
+func (sto *s3Storage) Fetch(b blob.Ref) (file io.ReadCloser, size uint32, err error) {
+	var rc io.ReadCloser
+	var sz int64
+	retryErr := retry.DefaultPolicy.Do(func() error {
+		var gerr error
+		rc, sz, gerr = sto.s3Client.Get(b.String(), sto.bucket)
+		return gerr
+	}, nil)
+	if retryErr != nil {
+		return nil, 0, retryErr
+	}
+	return rc, uint32(sz), nil
+}
+
+// StatBlobs sends a blob.SizedRef on dest for each of blobs that
+// exists in the bucket.
+func (sto *s3Storage) StatBlobs(dest chan<- blob.SizedRef, blobs []blob.Ref) error {
+	for _, br := range blobs {
+		var size int64
+		err := retry.DefaultPolicy.Do(func() error {
+			var serr error
+			size, serr = sto.s3Client.Stat(br.String(), sto.bucket)
+			return serr
+		}, nil)
+		if err == os.ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dest <- blob.SizedRef{Ref: br, Size: uint32(size)}
+	}
+	return nil
+}
+
+// RemoveBlobs deletes each of blobs from the bucket.
+func (sto *s3Storage) RemoveBlobs(blobs []blob.Ref) error {
+	for _, br := range blobs {
+		err := retry.DefaultPolicy.Do(func() error {
+			return sto.s3Client.Delete(br.String(), sto.bucket)
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnumerateBlobs sends up to limit blobs in the bucket, in sorted
+// order, with blobref strings greater than after.
+func (sto *s3Storage) EnumerateBlobs(dest chan<- blob.SizedRef, after string, limit int) error {
+	defer close(dest)
+	var entries []listEntry
+	err := retry.DefaultPolicy.Do(func() error {
+		var lerr error
+		entries, _, lerr = sto.s3Client.List(sto.bucket, after, limit)
+		return lerr
+	}, nil)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		br, ok := blob.Parse(e.Key)
+		if !ok {
+			continue
+		}
+		dest <- blob.SizedRef{Ref: br, Size: uint32(e.Size)}
+	}
+	return nil
+}
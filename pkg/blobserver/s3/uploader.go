@@ -0,0 +1,37 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+
+	"camlistore.org/pkg/blobserver/objstore"
+)
+
+// s3Uploader adapts s3Client to objstore.Uploader so s3Storage's
+// single-PUT ReceiveBlob path can share the generic slurp-then-upload
+// scaffolding in pkg/blobserver/objstore with the other backends.
+type s3Uploader struct {
+	client *s3Client
+	bucket string
+}
+
+func (u *s3Uploader) Put(ctx context.Context, key string, size int64, md5, sha256 []byte, body io.Reader) error {
+	return u.client.PutObject(key, u.bucket, md5, size, body, hex.EncodeToString(sha256))
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// HTTPStatusError is the error type object-storage clients (s3, gcs,
+// azure, ...) should return for a non-2xx HTTP response, so Classify
+// can tell transient failures (5xx, throttling) from permanent ones
+// (4xx auth/validation) without string-matching response bodies.
+type HTTPStatusError struct {
+	Op         string // e.g. "PUT bucket/key"
+	StatusCode int
+	Code       string // provider-specific error code, e.g. S3's "SlowDown"; may be empty
+}
+
+func (e *HTTPStatusError) Error() string {
+	msg := e.Op + ": HTTP " + httpStatusText(e.StatusCode)
+	if e.Code != "" {
+		msg += " (" + e.Code + ")"
+	}
+	return msg
+}
+
+// transientCodes are provider error codes that mean "retry me" even
+// though the HTTP status alone wouldn't say so (S3 returns 400/403
+// for some of these).
+var transientCodes = map[string]bool{
+	"SlowDown":             true,
+	"RequestTimeout":       true,
+	"RequestTimeTooSkewed": true,
+	"InternalError":        true,
+	"ServiceUnavailable":   true,
+}
+
+// Classify reports whether err represents a transient failure worth
+// retrying: network timeouts and resets, 5xx and 429 HTTP responses,
+// and known provider throttling/timeout error codes. Permanent
+// failures -- bad credentials, malformed requests, missing buckets --
+// are reported as non-transient so callers fail fast instead of
+// retrying something that will never succeed.
+func Classify(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(transientError); ok {
+		return true
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		if transientCodes[statusErr.Code] {
+			return true
+		}
+		return statusErr.StatusCode == 429 || statusErr.StatusCode >= 500
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return true
+		}
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	return false
+}
+
+// transientError marks an error as always-transient, regardless of
+// its underlying cause. It exists so fault injectors (like the s3
+// package's CAMLI_S3_FAIL_PERCENT hook) can exercise retry paths
+// deterministically by routing their synthetic errors through the
+// same classifier real errors use.
+type transientError struct{ error }
+
+// Transient wraps err so Classify always reports it as retryable.
+func Transient(err error) error { return transientError{err} }
+
+func httpStatusText(code int) string {
+	if code == 0 {
+		return "unknown status"
+	}
+	return strconv.Itoa(code)
+}
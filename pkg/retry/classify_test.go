@@ -0,0 +1,65 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+)
+
+type fakeTimeoutError struct{ timeout bool }
+
+func (e fakeTimeoutError) Error() string   { return "fake net error" }
+func (e fakeTimeoutError) Timeout() bool   { return e.timeout }
+func (e fakeTimeoutError) Temporary() bool { return e.timeout }
+
+var _ net.Error = fakeTimeoutError{}
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil is not transient", nil, false},
+		{"plain error is not transient", errors.New("boom"), false},
+		{"Transient-wrapped error is always transient", Transient(errors.New("injected")), true},
+		{"5xx is transient", &HTTPStatusError{Op: "PUT", StatusCode: 503}, true},
+		{"429 is transient", &HTTPStatusError{Op: "PUT", StatusCode: 429}, true},
+		{"404 is not transient", &HTTPStatusError{Op: "GET", StatusCode: 404}, false},
+		{"403 with no code is not transient", &HTTPStatusError{Op: "PUT", StatusCode: 403}, false},
+		{"403 SlowDown is transient", &HTTPStatusError{Op: "PUT", StatusCode: 403, Code: "SlowDown"}, true},
+		{"400 RequestTimeTooSkewed is transient", &HTTPStatusError{Op: "PUT", StatusCode: 400, Code: "RequestTimeTooSkewed"}, true},
+		{"400 with unrecognized code is not transient", &HTTPStatusError{Op: "PUT", StatusCode: 400, Code: "InvalidArgument"}, false},
+		{"timeout net.Error is transient", fakeTimeoutError{timeout: true}, true},
+		{"non-timeout net.Error is not transient", fakeTimeoutError{timeout: false}, false},
+		{"ECONNRESET is transient", syscall.ECONNRESET, true},
+		{"EPIPE is transient", syscall.EPIPE, true},
+		{"ECONNABORTED is transient", syscall.ECONNABORTED, true},
+		{"wrapped ECONNRESET is transient", fmt.Errorf("dial: %w", syscall.ECONNRESET), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Classify(c.err); got != c.want {
+				t.Errorf("Classify(%v) = %v, want %v", c.err, got, c.want)
+			}
+		})
+	}
+}
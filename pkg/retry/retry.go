@@ -0,0 +1,96 @@
+/*
+Copyright 2014 Google Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package retry classifies errors from network/object-storage
+// operations as transient or permanent, and retries transient
+// failures with exponential backoff and jitter. It was split out of
+// pkg/blobserver/s3, whose CAMLI_S3_FAIL_PERCENT fault injector and
+// ad-hoc PutObject error handling both wanted this same logic.
+package retry
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Policy configures how an operation is retried: how many times it's
+// attempted in total, and the exponential backoff (with full jitter,
+// as recommended by the AWS architecture blog) applied between
+// attempts.
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultPolicy is a reasonable default for operations against
+// object-storage backends: up to 5 attempts, starting at 100ms and
+// capping at 10s.
+var DefaultPolicy = Policy{
+	MaxAttempts: 5,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// Do calls op, retrying with exponential backoff and jitter as long
+// as Classify reports the returned error as transient, up to
+// MaxAttempts total calls of op. Before each retry (but not the
+// first attempt), seek is called if non-nil, so callers whose op
+// reads from a body can rewind it; if seek returns an error, Do
+// returns that error immediately without retrying further.
+func (p Policy) Do(op func() error, seek func() error) error {
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			if seek != nil {
+				if serr := seek(); serr != nil {
+					return serr
+				}
+			}
+			time.Sleep(p.backoff(attempt - 1))
+		}
+		err = op()
+		if err == nil {
+			return nil
+		}
+		if !Classify(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// backoff returns a jittered delay for the n'th retry (n starting at
+// 1), using full jitter: a uniform random duration between 0 and
+// min(MaxDelay, BaseDelay*2^n).
+func (p Policy) backoff(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < n; i++ {
+		d *= 2
+		if d <= 0 || d > p.MaxDelay {
+			d = p.MaxDelay
+			break
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}